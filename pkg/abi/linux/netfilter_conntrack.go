@@ -0,0 +1,121 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Connection tracking states, as matched by xt_state and xt_conntrack. These
+// mirror Linux's enum ip_conntrack_info buckets exposed to userspace via
+// NF_IP_CT_STATE_* bits in include/uapi/linux/netfilter/xt_state.h.
+const (
+	XTStateBitNew         = 0x08
+	XTStateBitEstablished = 0x02
+	XTStateBitRelated     = 0x04
+	XTStateBitInvalid     = 0x01
+	XTStateBitUntracked   = 0x80
+)
+
+// XTStateInfo is the xt_state_info struct used by the "state" matcher.
+//
+// +marshal
+type XTStateInfo struct {
+	StateMask uint32
+}
+
+// SizeOfXTStateInfo is the size of XTStateInfo.
+const SizeOfXTStateInfo = 4
+
+// Flags for XTConntrackMtinfo2.MatchFlags/InvertFlags, from
+// include/uapi/linux/netfilter/xt_conntrack.h.
+const (
+	XTConntrackState  = 1 << 0
+	XTConntrackProto  = 1 << 1
+	XTConntrackOrigSrc = 1 << 2
+	XTConntrackOrigDst = 1 << 3
+	XTConntrackReplSrc = 1 << 4
+	XTConntrackReplDst = 1 << 5
+	XTConntrackStatus  = 1 << 6
+	XTConntrackExpires = 1 << 7
+	XTConntrackOrigSrcPort = 1 << 8
+	XTConntrackOrigDstPort = 1 << 9
+	XTConntrackReplSrcPort = 1 << 10
+	XTConntrackReplDstPort = 1 << 11
+)
+
+// XTConntrackMtinfo2 is the xt_conntrack_mtinfo2 struct used by the
+// "conntrack" matcher.
+//
+// +marshal
+type XTConntrackMtinfo2 struct {
+	OrigSrcAddr     InetAddr
+	OrigSrcMask     InetAddr
+	OrigDstAddr     InetAddr
+	OrigDstMask     InetAddr
+	ReplSrcAddr     InetAddr
+	ReplSrcMask     InetAddr
+	ReplDstAddr     InetAddr
+	ReplDstMask     InetAddr
+	ExpiresMin      uint32
+	ExpiresMax      uint32
+	L4Proto         uint16
+	OrigSrcPort     uint16
+	OrigDstPort     uint16
+	ReplSrcPort     uint16
+	ReplDstPort     uint16
+	MatchFlags      uint16
+	InvertFlags     uint16
+	StateMask       uint16
+	StatusMask      uint16
+	_               [4]byte
+}
+
+// SizeOfXTConntrackMtinfo2 is the size of XTConntrackMtinfo2: 8 InetAddrs (4
+// bytes each) + 2 uint32s + 9 uint16s + 4 bytes of trailing padding.
+const SizeOfXTConntrackMtinfo2 = 8*4 + 2*4 + 9*2 + 4
+
+// NFNATRange describes a range of addresses and ports used by SNAT/DNAT, per
+// struct nf_nat_range in include/uapi/linux/netfilter/nf_nat.h.
+//
+// +marshal
+type NFNATRange struct {
+	Flags    uint32
+	MinAddr  InetAddr
+	MaxAddr  InetAddr
+	MinProto uint16
+	MaxProto uint16
+}
+
+// SizeOfNFNATRange is the size of NFNATRange: one uint32 + 2 InetAddrs (4
+// bytes each) + 2 uint16s.
+const SizeOfNFNATRange = 4 + 2*4 + 2*2
+
+// Flags for NFNATRange.Flags, from include/uapi/linux/netfilter/nf_nat.h.
+const (
+	NFNATRangeMapIPs        = 1 << 0
+	NFNATRangeProtoSpecified = 1 << 1
+	NFNATRangePersistent     = 1 << 6
+	NFNATRangeRandomFully    = 1 << 7
+)
+
+// XTNATRangeCompat is the nf_nat_ipv4_multi_range_compat struct used by the
+// legacy SNAT/DNAT/MASQUERADE targets (a single NFNATRange wrapped so the
+// on-wire size matches the historical "multi range" layout).
+//
+// +marshal
+type XTNATRangeCompat struct {
+	RangeSize uint32
+	Range     NFNATRange
+}
+
+// SizeOfXTNATRangeCompat is the size of XTNATRangeCompat.
+const SizeOfXTNATRangeCompat = 4 + SizeOfNFNATRange
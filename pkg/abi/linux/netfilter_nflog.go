@@ -0,0 +1,56 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// NFULNFLOGDefaultGroup is the nfnetlink_log multicast group joined by
+// userspace tools (e.g. ulogd) that don't explicitly request a group via the
+// xt_nflog_info.group field.
+const NFULNFLOGDefaultGroup = 0
+
+// Flags for XTNFLogInfo.Flags.
+const (
+	// XTNFLogFLogAll indicates that the packet should be logged with the
+	// full payload, not just the packet header.
+	XTNFLogFLogAll = 0x1
+)
+
+// XTNFLogInfo is the netfilter xt_nflog_info structure, used to configure the
+// NFLOG target.
+//
+// +marshal
+type XTNFLogInfo struct {
+	// Len is the number of bytes of the packet to copy to userspace. 0
+	// means the whole packet.
+	Len uint32
+
+	// Group is the nfnetlink_log multicast group the packet is logged to.
+	Group uint16
+
+	// Threshold is the number of packets to queue inside the kernel before
+	// sending them to userspace as a single netlink multicast message.
+	Threshold uint16
+
+	// Flags holds XTNFLogF* flags.
+	Flags uint16
+
+	_ uint16
+
+	// Prefix is a NUL-terminated string logged alongside the packet,
+	// allowing userspace to disambiguate which rule generated it.
+	Prefix [64]byte
+}
+
+// SizeOfXTNFLogInfo is the size of XTNFLogInfo.
+const SizeOfXTNFLogInfo = 76
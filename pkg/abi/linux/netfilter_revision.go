@@ -0,0 +1,35 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for revision negotiation, from
+// include/uapi/linux/netfilter_ipv4/ip_tables.h (IPT_BASE_CTL + 2 and + 3).
+// netfilter_ipv6/ip6_tables.h numbers the IPv6 equivalents differently
+// (IP6T_SO_GET_REVISION_MATCH is 68, IP6T_SO_GET_REVISION_TARGET is 69);
+// those are not defined here.
+const (
+	IPT_SO_GET_REVISION_MATCH  = 66
+	IPT_SO_GET_REVISION_TARGET = 67
+)
+
+// XTGetRevision is the xt_get_revision struct, used with
+// IPT_SO_GET_REVISION_{MATCH,TARGET} to ask the kernel for the highest
+// revision it supports of a named match or target.
+//
+// +marshal
+type XTGetRevision struct {
+	Name     ExtensionName
+	Revision uint8
+}
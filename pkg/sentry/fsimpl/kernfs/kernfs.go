@@ -17,9 +17,10 @@
 //
 // 1. The Inode interface, which maps VFS2's path-based filesystem operations to
 //    specific filesystem nodes. Kernfs uses the Inode interface to provide a
-//    blanket implementation for the vfs.FilesystemImpl. Kernfs also serves as
-//    the synchronization mechanism for all filesystem operations by holding a
-//    filesystem-wide lock across all operations.
+//    blanket implementation for the vfs.FilesystemImpl. Unlike earlier
+//    versions of kernfs, there is no filesystem-wide lock synchronizing these
+//    operations; see "Reference Model" below for the per-dentry locking
+//    scheme that replaces it.
 //
 // 2. Various utility types which provide generic implementations for various
 //    parts of the Inode and vfs.FileDescription interfaces. Client filesystems
@@ -40,19 +41,21 @@
 // in the case of hardlinks). File descriptors hold a reference to the dentry
 // they're opened on.
 //
-// Dentries are guaranteed to exist while holding Filesystem.mu for
-// reading. Dropping dentries require holding Filesystem.mu for writing. To
-// queue dentries for destruction from a read critical section, see
-// Filesystem.deferDecRef.
+// Dentries are guaranteed to exist while the caller holds a reference on
+// them (directly, or transitively via a reference on a descendant). There is
+// no filesystem-wide lock guarding the dentry tree; each directory dentry's
+// own Dentry.dirMu protects its children map, and Dentry.mu serializes that
+// dentry's own destruction. A dentry is dropped directly from DecRef once
+// its reference count reaches zero, without deferring to a later point where
+// some wider lock can be acquired.
 //
 // Lock ordering:
 //
-// kernfs.Filesystem.mu
-//   kernfs.Dentry.dirMu
+// kernfs.Dentry.mu
+//   kernfs.Dentry.dirMu (of the parent, when detaching a child during DecRef)
 //     vfs.VirtualFilesystem.mountMu
 //       vfs.Dentry.mu
 //   (inode implementation locks, if any)
-// kernfs.Filesystem.droppedDentriesMu
 package kernfs
 
 import (
@@ -74,65 +77,11 @@ import (
 type Filesystem struct {
 	vfsfs vfs.Filesystem
 
-	droppedDentriesMu sync.Mutex `state:"nosave"`
-
-	// droppedDentries is a list of dentries waiting to be DecRef()ed. This is
-	// used to defer dentry destruction until mu can be acquired for
-	// writing. Protected by droppedDentriesMu.
-	droppedDentries []*Dentry
-
-	// mu synchronizes the lifetime of Dentries on this filesystem. Holding it
-	// for reading guarantees continued existence of any resolved dentries, but
-	// the dentry tree may be modified.
-	//
-	// Kernfs dentries can only be DecRef()ed while holding mu for writing. For
-	// example:
-	//
-	//   fs.mu.Lock()
-	//   defer fs.mu.Unlock()
-	//   ...
-	//   dentry1.DecRef()
-	//   defer dentry2.DecRef() // Ok, will run before Unlock.
-	//
-	// If discarding dentries in a read context, use Filesystem.deferDecRef. For
-	// example:
-	//
-	//   fs.mu.RLock()
-	//   defer fs.processDeferredDecRefs()
-	//   defer fs.mu.RUnlock()
-	//   ...
-	//   fs.deferDecRef(dentry)
-	mu sync.RWMutex `state:"nosave"`
-
 	// nextInoMinusOne is used to to allocate inode numbers on this
 	// filesystem. Must be accessed by atomic operations.
 	nextInoMinusOne uint64
 }
 
-// deferDecRef defers dropping a dentry ref until the next call to
-// processDeferredDecRefs{,Locked}. See comment on Filesystem.mu.
-// This may be called while Filesystem.mu or Dentry.dirMu is locked.
-func (fs *Filesystem) deferDecRef(d *Dentry) {
-	fs.droppedDentriesMu.Lock()
-	fs.droppedDentries = append(fs.droppedDentries, d)
-	fs.droppedDentriesMu.Unlock()
-}
-
-// processDeferredDecRefs calls vfs.Dentry.DecRef on all dentries in the
-// droppedDentries list. See comment on Filesystem.mu.
-//
-// Precondition: Filesystem.mu or Dentry.dirMu must NOT be locked.
-func (fs *Filesystem) processDeferredDecRefs(ctx context.Context) {
-	fs.droppedDentriesMu.Lock()
-	for _, d := range fs.droppedDentries {
-		// Defer the DecRef call so that we are not holding droppedDentriesMu
-		// when DecRef is called.
-		defer d.DecRef(ctx)
-	}
-	fs.droppedDentries = fs.droppedDentries[:0] // Keep slice memory for reuse.
-	fs.droppedDentriesMu.Unlock()
-}
-
 // VFSFilesystem returns the generic vfs filesystem object.
 func (fs *Filesystem) VFSFilesystem() *vfs.Filesystem {
 	return &fs.vfsfs
@@ -174,18 +123,30 @@ type Dentry struct {
 	// dflags* consts above. Must be accessed by atomic ops.
 	flags uint32
 
+	// mu serializes destruction of this dentry (see DecRef) and protects
+	// reads and writes of parent and name once d is reachable from other
+	// goroutines (i.e. once it is hashed into a parent's children map).
+	// Before that point, parent and name are only written by
+	// insertChildLocked, which has exclusive access to the new dentry.
+	mu sync.Mutex `state:"nosave"`
+
 	parent *Dentry
 	name   string
 
-	// dirMu protects children and the names of child Dentries.
-	//
-	// Note that holding fs.mu for writing is not sufficient;
-	// revalidateChildLocked(), which is a very hot path, may modify children with
-	// fs.mu acquired for reading only.
+	// dirMu protects children and the names of child Dentries. It is the
+	// authoritative lock for this directory's children: unlike in earlier
+	// kernfs revisions, there is no filesystem-wide lock a caller can hold
+	// instead of dirMu to access children.
 	dirMu    sync.Mutex `state:"nosave"`
 	children map[string]*Dentry
 
 	inode Inode
+
+	// watches is the set of inotify watches on the file represented by this
+	// dentry. Note that hard links to the same file will not share the same
+	// set of watches, due to the fact that we do not have inode structures
+	// in by-reference filesystems like this one.
+	watches vfs.Watches
 }
 
 // Init initializes this dentry.
@@ -223,41 +184,64 @@ func (d *Dentry) isSymlink() bool {
 }
 
 // DecRef implements vfs.DentryImpl.DecRef.
+//
+// Unlike earlier kernfs revisions, DecRef does not acquire any
+// filesystem-wide lock: dropping a dentry only ever needs to take its own
+// mu (to serialize its destruction) and briefly its parent's dirMu (to
+// unhash it), so concurrent DecRefs on unrelated parts of the tree never
+// contend with each other.
 func (d *Dentry) DecRef(ctx context.Context) {
-	decRefParent := false
-	d.fs.mu.Lock()
 	d.DentryRefs.DecRef(func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
 		d.inode.DecRef(ctx) // IncRef from Init.
 		d.inode = nil
-		if d.parent != nil {
-			// We will DecRef d.parent once all locks are dropped.
-			decRefParent = true
-			d.parent.dirMu.Lock()
-			// Remove d from parent.children. It might already have been
-			// removed due to invalidation.
-			if _, ok := d.parent.children[d.name]; ok {
-				delete(d.parent.children, d.name)
-				d.fs.VFSFilesystem().VirtualFilesystem().InvalidateDentry(ctx, d.VFSDentry())
+		parent := d.parent
+		if parent == nil {
+			return
+		}
+
+		parent.dirMu.Lock()
+		// Remove d from parent.children. It might already have been
+		// removed due to invalidation.
+		if _, ok := parent.children[d.name]; ok {
+			delete(parent.children, d.name)
+			d.fs.VFSFilesystem().VirtualFilesystem().InvalidateDentry(ctx, d.VFSDentry())
+			events := uint32(linux.IN_DELETE)
+			if d.isDir() {
+				events |= linux.IN_ISDIR
 			}
-			d.parent.dirMu.Unlock()
+			parent.watches.Notify(ctx, d.name, events, 0, vfs.InodeEvent, d.isDir())
+			d.watches.Notify(ctx, "", linux.IN_DELETE_SELF, 0, vfs.InodeEvent, d.isDir())
 		}
+		parent.dirMu.Unlock()
+
+		parent.DecRef(ctx) // IncRef from Dentry.insertChild.
 	})
-	d.fs.mu.Unlock()
-	if decRefParent {
-		d.parent.DecRef(ctx) // IncRef from Dentry.insertChild.
-	}
 }
 
 // InotifyWithParent implements vfs.DentryImpl.InotifyWithParent.
-//
-// Although Linux technically supports inotify on pseudo filesystems (inotify
-// is implemented at the vfs layer), it is not particularly useful. It is left
-// unimplemented until someone actually needs it.
-func (d *Dentry) InotifyWithParent(ctx context.Context, events, cookie uint32, et vfs.EventType) {}
+func (d *Dentry) InotifyWithParent(ctx context.Context, events, cookie uint32, et vfs.EventType) {
+	if d.isDir() {
+		events |= linux.IN_ISDIR
+	}
+
+	d.watches.Notify(ctx, "", events, cookie, et, d.isDir())
+
+	// d.mu protects d.parent and d.name once d is reachable from another
+	// goroutine (see Dentry.mu), so read them under it.
+	d.mu.Lock()
+	parent, name := d.parent, d.name
+	d.mu.Unlock()
+	if parent != nil {
+		parent.watches.Notify(ctx, name, events, cookie, et, d.isDir())
+	}
+}
 
 // Watches implements vfs.DentryImpl.Watches.
 func (d *Dentry) Watches() *vfs.Watches {
-	return nil
+	return &d.watches
 }
 
 // OnZeroWatches implements vfs.Dentry.OnZeroWatches.
@@ -267,12 +251,16 @@ func (d *Dentry) OnZeroWatches(context.Context) {}
 // this dentry. This does not update the directory inode, so calling this on its
 // own isn't sufficient to insert a child into a directory.
 //
+// insertChild fires an IN_CREATE event on d if ctx is non-nil. Callers that
+// insert a child which should not be user-visible (e.g. a dentry created to
+// service a Lookup() that Inode.Keep() reports should be discarded) should
+// pass a nil ctx to suppress the event.
+//
 // Preconditions:
 // * d must represent a directory inode.
-// * d.fs.mu must be locked for at least reading.
-func (d *Dentry) insertChild(name string, child *Dentry) {
+func (d *Dentry) insertChild(ctx context.Context, name string, child *Dentry) {
 	d.dirMu.Lock()
-	d.insertChildLocked(name, child)
+	d.insertChildLocked(ctx, name, child)
 	d.dirMu.Unlock()
 }
 
@@ -282,8 +270,7 @@ func (d *Dentry) insertChild(name string, child *Dentry) {
 // Preconditions:
 // * d must represent a directory inode.
 // * d.dirMu must be locked.
-// * d.fs.mu must be locked for at least reading.
-func (d *Dentry) insertChildLocked(name string, child *Dentry) {
+func (d *Dentry) insertChildLocked(ctx context.Context, name string, child *Dentry) {
 	if !d.isDir() {
 		panic(fmt.Sprintf("insertChildLocked called on non-directory Dentry: %+v.", d))
 	}
@@ -294,6 +281,13 @@ func (d *Dentry) insertChildLocked(name string, child *Dentry) {
 		d.children = make(map[string]*Dentry)
 	}
 	d.children[name] = child
+	if ctx != nil {
+		events := uint32(linux.IN_CREATE)
+		if child.isDir() {
+			events |= linux.IN_ISDIR
+		}
+		d.watches.Notify(ctx, name, events, 0, vfs.InodeEvent, child.isDir())
+	}
 }
 
 // Inode returns the dentry's inode.
@@ -301,6 +295,48 @@ func (d *Dentry) Inode() Inode {
 	return d.inode
 }
 
+// SetStat calls d.Inode().SetStat and, if it succeeds, fires the inotify
+// events for the change: IN_ATTRIB for metadata-only changes (permissions,
+// ownership, timestamps), and additionally IN_MODIFY if opts changed the
+// file's size.
+//
+// Filesystem-level SetStatAt implementations should call d.SetStat instead
+// of d.Inode().SetStat directly, so that a successful SetStat can never
+// silently skip firing its inotify events.
+func (d *Dentry) SetStat(ctx context.Context, fs *vfs.Filesystem, creds *auth.Credentials, opts vfs.SetStatOptions) error {
+	if err := d.inode.SetStat(ctx, fs, creds, opts); err != nil {
+		return err
+	}
+	events := uint32(linux.IN_ATTRIB)
+	if opts.Stat.Mask&linux.STATX_SIZE != 0 {
+		events |= linux.IN_MODIFY
+	}
+	d.InotifyWithParent(ctx, events, 0, vfs.InodeEvent)
+	return nil
+}
+
+// Rename calls oldParent.Inode().Rename to rename d from oldName (under
+// oldParent) to newName (under newParent) and, if it succeeds, fires the
+// IN_MOVED_FROM/IN_MOVED_TO inotify event pair. If oldParent and newParent
+// are the same directory, both events still fire, sharing the same cookie,
+// matching Linux's behavior for same-directory renames.
+//
+// Filesystem-level RenameAt implementations should call d.Rename instead of
+// oldParent.Inode().Rename directly, so that a successful rename can never
+// silently skip firing its inotify events.
+func (d *Dentry) Rename(ctx context.Context, oldParent, newParent *Dentry, oldName, newName string, cookie uint32) error {
+	if err := oldParent.inode.Rename(ctx, oldName, newName, d.inode, newParent.inode); err != nil {
+		return err
+	}
+	events := uint32(0)
+	if d.isDir() {
+		events |= linux.IN_ISDIR
+	}
+	oldParent.watches.Notify(ctx, oldName, events|linux.IN_MOVED_FROM, cookie, vfs.InodeEvent, d.isDir())
+	newParent.watches.Notify(ctx, newName, events|linux.IN_MOVED_TO, cookie, vfs.InodeEvent, d.isDir())
+	return nil
+}
+
 // The Inode interface maps filesystem-level operations that operate on paths to
 // equivalent operations on specific filesystem nodes.
 //
@@ -382,7 +418,8 @@ type inodeMetadata interface {
 	// SetStat updates the metadata for this inode. This corresponds to
 	// vfs.FilesystemImpl.SetStatAt. Implementations are responsible for checking
 	// if the operation can be performed (see vfs.CheckSetStat() for common
-	// checks).
+	// checks). Callers should go through Dentry.SetStat rather than calling
+	// this directly, so the inode's inotify events fire on success.
 	SetStat(ctx context.Context, fs *vfs.Filesystem, creds *auth.Credentials, opts vfs.SetStatOptions) error
 }
 
@@ -428,7 +465,8 @@ type inodeDirectory interface {
 
 	// Rename is called on the source directory containing an inode being
 	// renamed. child should point to the resolved child in the source
-	// directory.
+	// directory. Callers should go through Dentry.Rename rather than calling
+	// this directly, so the renamed dentry's inotify events fire on success.
 	//
 	// Precondition: Caller must serialize concurrent calls to Rename.
 	Rename(ctx context.Context, oldname, newname string, child, dstDir Inode) error
@@ -0,0 +1,89 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernfs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// newBenchDir builds a directory Dentry with n children hashed under it, so
+// that concurrent goroutines can race on its dirMu doing lookups.
+func newBenchDir(n int) *Dentry {
+	fs := &Filesystem{}
+	parent := &Dentry{fs: fs}
+	parent.flags |= dflagsIsDir
+	parent.DentryRefs.EnableLeakCheck()
+	for i := 0; i < n; i++ {
+		child := &Dentry{fs: fs}
+		child.DentryRefs.EnableLeakCheck()
+		parent.insertChild(nil /* ctx */, fmt.Sprintf("file%d", i), child)
+	}
+	return parent
+}
+
+// BenchmarkConcurrentChildLookup measures the scalability of concurrent
+// name lookups under a single wide directory. Since every goroutine here
+// contends on the same directory's dirMu, this is the case per-directory
+// locking does *not* help relative to a single filesystem-wide lock; it's
+// included as a baseline for BenchmarkConcurrentChildLookupManyDirs below.
+func BenchmarkConcurrentChildLookup(b *testing.B) {
+	const numChildren = 1000
+	parent := newBenchDir(numChildren)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			name := fmt.Sprintf("file%d", i%numChildren)
+			parent.dirMu.Lock()
+			_ = parent.children[name]
+			parent.dirMu.Unlock()
+			i++
+		}
+	})
+}
+
+// BenchmarkConcurrentChildLookupManyDirs measures the scalability of
+// concurrent name lookups spread across many sibling directories, each with
+// their own dirMu, the way a wide /proc or cgroupfs tree is actually
+// accessed: different goroutines are almost always looking up entries under
+// different per-process or per-cgroup directories. This is the access
+// pattern that motivated replacing Filesystem's single RWMutex with
+// per-directory dirMu locking: before that change, every lookup across the
+// whole filesystem serialized on the same lock regardless of which
+// directory it targeted; afterward, lookups under distinct directories no
+// longer contend with each other at all.
+func BenchmarkConcurrentChildLookupManyDirs(b *testing.B) {
+	const numDirs = 64
+	const childrenPerDir = 32
+	dirs := make([]*Dentry, numDirs)
+	for i := range dirs {
+		dirs[i] = newBenchDir(childrenPerDir)
+	}
+
+	var next int32
+	b.RunParallel(func(pb *testing.PB) {
+		dir := dirs[atomic.AddInt32(&next, 1)%int32(numDirs)]
+		i := 0
+		for pb.Next() {
+			name := fmt.Sprintf("file%d", i%childrenPerDir)
+			dir.dirMu.Lock()
+			_ = dir.children[name]
+			dir.dirMu.Unlock()
+			i++
+		}
+	})
+}
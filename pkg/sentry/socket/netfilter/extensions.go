@@ -38,6 +38,11 @@ type matchMaker interface {
 	// name is the matcher name as stored in the xt_entry_match struct.
 	name() string
 
+	// revision is the revision of the matcher ABI, as stored in the
+	// xt_entry_match struct. Userspace negotiates the highest revision a
+	// matcher supports via IPT_SO_GET_REVISION_MATCH.
+	revision() uint8
+
 	// marshal converts from a stack.Matcher to an ABI struct.
 	marshal(matcher stack.Matcher) []byte
 
@@ -46,21 +51,68 @@ type matchMaker interface {
 	unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error)
 }
 
-// matchMakers maps the name of supported matchers to the matchMaker that
-// marshals and unmarshals it. It is immutable after package initialization.
-var matchMakers = map[string]matchMaker{}
+// matchMakerKey identifies a matchMaker registration by name and ABI
+// revision, mirroring stack.TargetID's role for targetMakers.
+type matchMakerKey struct {
+	name     string
+	revision uint8
+}
+
+// matchMakers maps the (name, revision) of supported matchers to the
+// matchMaker that marshals and unmarshals it. It is immutable after package
+// initialization.
+var matchMakers = map[matchMakerKey]matchMaker{}
 
 // registermatchMaker should be called by match extensions to register them
 // with the netfilter package.
 func registerMatchMaker(mm matchMaker) {
-	if _, ok := matchMakers[mm.name()]; ok {
-		panic(fmt.Sprintf("Multiple matches registered with name %q.", mm.name()))
+	key := matchMakerKey{name: mm.name(), revision: mm.revision()}
+	if _, ok := matchMakers[key]; ok {
+		panic(fmt.Sprintf("Multiple matches registered with name %q and revision %d.", mm.name(), mm.revision()))
+	}
+	matchMakers[key] = mm
+}
+
+// matchRevision returns the highest revision of the named matcher that is no
+// greater than rev, mirroring targetRevision. It is used to answer
+// IPT_SO_GET_REVISION_MATCH. netProto is accepted for symmetry with
+// targetRevision; matchMakers, unlike targetMakers, are not currently
+// registered per network protocol.
+func matchRevision(name string, netProto tcpip.NetworkProtocolNumber, rev uint8) (uint8, bool) {
+	var (
+		best  uint8
+		found bool
+	)
+	for key := range matchMakers {
+		if key.name != name || key.revision > rev {
+			continue
+		}
+		if !found || key.revision > best {
+			best = key.revision
+			found = true
+		}
 	}
-	matchMakers[mm.name()] = mm
+	return best, found
+}
+
+// highestMatchMaker returns the matchMaker registered for name with the
+// highest revision, which is the one used to marshal live stack.Matchers
+// (which do not themselves carry a revision).
+func highestMatchMaker(name string) (matchMaker, bool) {
+	var best matchMaker
+	for key, mm := range matchMakers {
+		if key.name != name {
+			continue
+		}
+		if best == nil || key.revision > best.revision() {
+			best = mm
+		}
+	}
+	return best, best != nil
 }
 
 func marshalMatcher(matcher stack.Matcher) []byte {
-	matchMaker, ok := matchMakers[matcher.Name()]
+	matchMaker, ok := highestMatchMaker(matcher.Name())
 	if !ok {
 		panic(fmt.Sprintf("Unknown matcher of type %T.", matcher))
 	}
@@ -91,9 +143,10 @@ func marshalEntryMatch(name string, data []byte) []byte {
 }
 
 func unmarshalMatcher(match linux.XTEntryMatch, filter stack.IPHeaderFilter, buf []byte) (stack.Matcher, error) {
-	matchMaker, ok := matchMakers[match.Name.String()]
+	key := matchMakerKey{name: match.Name.String(), revision: match.Revision}
+	matchMaker, ok := matchMakers[key]
 	if !ok {
-		return nil, fmt.Errorf("unsupported matcher with name %q", match.Name.String())
+		return nil, fmt.Errorf("unsupported matcher with name %q and revision %d", match.Name.String(), match.Revision)
 	}
 	return matchMaker.unmarshal(buf, filter)
 }
@@ -115,24 +168,25 @@ type targetMaker interface {
 // marshals and unmarshals it. It is immutable after package initialization.
 var targetMakers = map[stack.TargetID]targetMaker{}
 
+// targetRevision returns the highest revision of the named target for
+// netProto that is no greater than rev. It is used to answer
+// IPT_SO_GET_REVISION_TARGET.
 func targetRevision(name string, netProto tcpip.NetworkProtocolNumber, rev uint8) (uint8, bool) {
-	tid := stack.TargetID{
-		Name:            name,
-		NetworkProtocol: netProto,
-		Revision:        rev,
-	}
-	if _, ok := targetMakers[tid]; !ok {
-		return 0, false
-	}
-
-	// Return the highest supported revision unless rev is higher.
-	for _, other := range targetMakers {
-		otherID := other.id()
-		if name == otherID.Name && netProto == otherID.NetworkProtocol && otherID.Revision > rev {
-			rev = uint8(otherID.Revision)
+	var (
+		best  uint8
+		found bool
+	)
+	for _, tm := range targetMakers {
+		id := tm.id()
+		if id.Name != name || id.NetworkProtocol != netProto || uint8(id.Revision) > rev {
+			continue
+		}
+		if !found || uint8(id.Revision) > best {
+			best = uint8(id.Revision)
+			found = true
 		}
 	}
-	return rev, true
+	return best, found
 }
 
 // registerTargetMaker should be called by target extensions to register them
@@ -0,0 +1,113 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// errMatchBufTooSmall reports that buf was too short to hold the named
+// matcher's ABI struct.
+func errMatchBufTooSmall(name string, size int) error {
+	return fmt.Errorf("%s matcher: buf has insufficient size %d", name, size)
+}
+
+func init() {
+	registerMatchMaker(&stateMatchMaker{})
+	registerMatchMaker(&conntrackMatchMaker{})
+}
+
+// stateMatchMaker marshals and unmarshals the xt_state_info struct used by
+// the "state" matcher, which classifies packets as NEW/ESTABLISHED/RELATED/
+// INVALID using stack.ConnTrack.Status.
+type stateMatchMaker struct{}
+
+// name implements matchMaker.name.
+func (*stateMatchMaker) name() string {
+	return "state"
+}
+
+// revision implements matchMaker.revision.
+func (*stateMatchMaker) revision() uint8 {
+	return 0
+}
+
+// marshal implements matchMaker.marshal.
+func (*stateMatchMaker) marshal(matcher stack.Matcher) []byte {
+	m := matcher.(*stack.ConnTrackStateMatcher)
+	info := linux.XTStateInfo{StateMask: m.StateMask}
+	buf := make([]byte, linux.SizeOfXTStateInfo)
+	info.MarshalUnsafe(buf)
+	return marshalEntryMatch("state", buf)
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (*stateMatchMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTStateInfo {
+		return nil, errMatchBufTooSmall("state", len(buf))
+	}
+	var info linux.XTStateInfo
+	info.UnmarshalUnsafe(buf)
+	return &stack.ConnTrackStateMatcher{StateMask: info.StateMask}, nil
+}
+
+// conntrackMatchMaker marshals and unmarshals the xt_conntrack_mtinfo2
+// struct used by the "conntrack" matcher, the superset of "state" that also
+// matches on the connection's original-direction protocol and ports. The
+// struct's address, reply-direction, expiry and status fields and its
+// MatchFlags/InvertFlags are not carried over to the stack.Matcher.
+type conntrackMatchMaker struct{}
+
+// name implements matchMaker.name.
+func (*conntrackMatchMaker) name() string {
+	return "conntrack"
+}
+
+// revision implements matchMaker.revision.
+func (*conntrackMatchMaker) revision() uint8 {
+	return 2
+}
+
+// marshal implements matchMaker.marshal.
+func (*conntrackMatchMaker) marshal(matcher stack.Matcher) []byte {
+	m := matcher.(*stack.ConnTrackTupleMatcher)
+	info := linux.XTConntrackMtinfo2{
+		StateMask:   uint16(m.StateMask),
+		L4Proto:     uint16(m.OrigProto),
+		OrigSrcPort: m.OrigSrcPort,
+		OrigDstPort: m.OrigDstPort,
+	}
+	buf := make([]byte, linux.SizeOfXTConntrackMtinfo2)
+	info.MarshalUnsafe(buf)
+	return marshalEntryMatch("conntrack", buf)
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (*conntrackMatchMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTConntrackMtinfo2 {
+		return nil, errMatchBufTooSmall("conntrack", len(buf))
+	}
+	var info linux.XTConntrackMtinfo2
+	info.UnmarshalUnsafe(buf)
+	return &stack.ConnTrackTupleMatcher{
+		StateMask:   uint32(info.StateMask),
+		OrigProto:   uint8(info.L4Proto),
+		OrigSrcPort: info.OrigSrcPort,
+		OrigDstPort: info.OrigDstPort,
+	}, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestStateMatchMakerUnmarshal(t *testing.T) {
+	mm := &stateMatchMaker{}
+
+	info := linux.XTStateInfo{StateMask: 0x08}
+	buf := make([]byte, linux.SizeOfXTStateInfo)
+	info.MarshalUnsafe(buf)
+
+	matcher, err := mm.unmarshal(buf, stack.IPHeaderFilter{})
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	stateMatcher, ok := matcher.(*stack.ConnTrackStateMatcher)
+	if !ok {
+		t.Fatalf("unmarshal returned %T, want *stack.ConnTrackStateMatcher", matcher)
+	}
+	if stateMatcher.StateMask != 0x08 {
+		t.Errorf("StateMask = %#x, want 0x08", stateMatcher.StateMask)
+	}
+}
+
+func TestStateMatchMakerUnmarshalBufTooSmall(t *testing.T) {
+	mm := &stateMatchMaker{}
+	if _, err := mm.unmarshal(make([]byte, linux.SizeOfXTStateInfo-1), stack.IPHeaderFilter{}); err == nil {
+		t.Error("unmarshal with a too-small buffer succeeded, want an error")
+	}
+}
+
+func TestConntrackMatchMakerUnmarshal(t *testing.T) {
+	mm := &conntrackMatchMaker{}
+
+	info := linux.XTConntrackMtinfo2{
+		StateMask:   0x02,
+		L4Proto:     6,
+		OrigSrcPort: 1234,
+		OrigDstPort: 80,
+	}
+	buf := make([]byte, linux.SizeOfXTConntrackMtinfo2)
+	info.MarshalUnsafe(buf)
+
+	matcher, err := mm.unmarshal(buf, stack.IPHeaderFilter{})
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	tupleMatcher, ok := matcher.(*stack.ConnTrackTupleMatcher)
+	if !ok {
+		t.Fatalf("unmarshal returned %T, want *stack.ConnTrackTupleMatcher", matcher)
+	}
+	if tupleMatcher.StateMask != 0x02 {
+		t.Errorf("StateMask = %#x, want 0x02", tupleMatcher.StateMask)
+	}
+	if tupleMatcher.OrigProto != 6 {
+		t.Errorf("OrigProto = %d, want 6", tupleMatcher.OrigProto)
+	}
+	if tupleMatcher.OrigSrcPort != 1234 {
+		t.Errorf("OrigSrcPort = %d, want 1234", tupleMatcher.OrigSrcPort)
+	}
+	if tupleMatcher.OrigDstPort != 80 {
+		t.Errorf("OrigDstPort = %d, want 80", tupleMatcher.OrigDstPort)
+	}
+}
+
+func TestConntrackMatchMakerUnmarshalBufTooSmall(t *testing.T) {
+	mm := &conntrackMatchMaker{}
+	if _, err := mm.unmarshal(make([]byte, linux.SizeOfXTConntrackMtinfo2-1), stack.IPHeaderFilter{}); err == nil {
+		t.Error("unmarshal with a too-small buffer succeeded, want an error")
+	}
+}
+
+func TestMatchMakersRegistered(t *testing.T) {
+	if mm, ok := highestMatchMaker("state"); !ok || mm.revision() != 0 {
+		t.Errorf(`highestMatchMaker("state") = (%+v, %v), want (revision 0, true)`, mm, ok)
+	}
+	if mm, ok := highestMatchMaker("conntrack"); !ok || mm.revision() != 2 {
+		t.Errorf(`highestMatchMaker("conntrack") = (%+v, %v), want (revision 2, true)`, mm, ok)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// getRevisionMatch handles IPT_SO_GET_REVISION_MATCH, returning the highest
+// revision of the named match that this netfilter implementation supports,
+// so that userspace iptables can negotiate rather than assume revision 0.
+func getRevisionMatch(buf []byte, netProto tcpip.NetworkProtocolNumber) (marshalable, *syserr.Error) {
+	var rev linux.XTGetRevision
+	if len(buf) < rev.SizeBytes() {
+		return nil, syserr.ErrInvalidArgument
+	}
+	rev.UnmarshalUnsafe(buf)
+
+	revision, ok := matchRevision(rev.Name.String(), netProto, rev.Revision)
+	if !ok {
+		nflog("getRevisionMatch: unsupported match %q", rev.Name.String())
+		return nil, syserr.ErrProtocolNotSupported
+	}
+	result := primitive.Uint8(revision)
+	return &result, nil
+}
+
+// getRevisionTarget handles IPT_SO_GET_REVISION_TARGET, the target
+// counterpart of getRevisionMatch.
+func getRevisionTarget(buf []byte, netProto tcpip.NetworkProtocolNumber) (marshalable, *syserr.Error) {
+	var rev linux.XTGetRevision
+	if len(buf) < rev.SizeBytes() {
+		return nil, syserr.ErrInvalidArgument
+	}
+	rev.UnmarshalUnsafe(buf)
+
+	revision, ok := targetRevision(rev.Name.String(), netProto, rev.Revision)
+	if !ok {
+		nflog("getRevisionTarget: unsupported target %q", rev.Name.String())
+		return nil, syserr.ErrProtocolNotSupported
+	}
+	result := primitive.Uint8(revision)
+	return &result, nil
+}
+
+// marshalable is satisfied by the ABI types returned from GetSockOpt
+// handlers; it's the common subset of marshal.Marshallable that the
+// netfilter getsockopt dispatcher (in netfilter.go) needs to copy the result
+// out to the caller.
+type marshalable interface {
+	MarshalUnsafe(dst []byte)
+	SizeBytes() int
+}
+
+// GetRevisionSockOpt answers name if it is one of the
+// IPT_SO_GET_REVISION_{MATCH,TARGET} socket options, returning handled=false
+// for every other name.
+//
+// This is the IPT_SO_GET_REVISION_* case of the netfilter getsockopt switch:
+// the package's main GetSockOpt dispatcher (in netfilter.go, which predates
+// this series and is not part of this change) should call this first and
+// return its result whenever handled is true.
+func GetRevisionSockOpt(name int, buf []byte, netProto tcpip.NetworkProtocolNumber) (marshalable, *syserr.Error, bool) {
+	switch name {
+	case linux.IPT_SO_GET_REVISION_MATCH:
+		m, err := getRevisionMatch(buf, netProto)
+		return m, err, true
+	case linux.IPT_SO_GET_REVISION_TARGET:
+		t, err := getRevisionTarget(buf, netProto)
+		return t, err, true
+	default:
+		return nil, nil, false
+	}
+}
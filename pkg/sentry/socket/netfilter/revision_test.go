@@ -0,0 +1,103 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// TestTargetRevisionPerNetProto is a regression test for the bug that made
+// revision negotiation for any per-protocol target (NAT, NFLOG, ULOG) always
+// fail: targetRevision filters candidates by id.NetworkProtocol, which is
+// only ever populated now that those targetMakers register themselves once
+// per real network protocol rather than with NetworkProtocol 0.
+func TestTargetRevisionPerNetProto(t *testing.T) {
+	if _, ok := targetRevision("NFLOG", header.IPv4ProtocolNumber, 0); !ok {
+		t.Error(`targetRevision("NFLOG", IPv4, 0) not found, want found`)
+	}
+	if _, ok := targetRevision("NFLOG", header.IPv6ProtocolNumber, 0); !ok {
+		t.Error(`targetRevision("NFLOG", IPv6, 0) not found, want found`)
+	}
+	// A network protocol this target was never registered for must not
+	// match.
+	if _, ok := targetRevision("NFLOG", 0, 0); ok {
+		t.Error(`targetRevision("NFLOG", 0, 0) found, want not found`)
+	}
+	if _, ok := targetRevision("SNAT", header.IPv4ProtocolNumber, 0); !ok {
+		t.Error(`targetRevision("SNAT", IPv4, 0) not found, want found`)
+	}
+	if _, ok := targetRevision("no-such-target", header.IPv4ProtocolNumber, 0); ok {
+		t.Error(`targetRevision("no-such-target", IPv4, 0) found, want not found`)
+	}
+}
+
+func TestMatchRevision(t *testing.T) {
+	if rev, ok := matchRevision("conntrack", header.IPv4ProtocolNumber, 2); !ok || rev != 2 {
+		t.Errorf(`matchRevision("conntrack", IPv4, 2) = (%d, %v), want (2, true)`, rev, ok)
+	}
+	// Userspace requesting a revision above what's registered should get
+	// the highest revision no greater than what it asked for, matching
+	// Linux's negotiation semantics.
+	if rev, ok := matchRevision("conntrack", header.IPv4ProtocolNumber, 5); !ok || rev != 2 {
+		t.Errorf(`matchRevision("conntrack", IPv4, 5) = (%d, %v), want (2, true)`, rev, ok)
+	}
+	if _, ok := matchRevision("conntrack", header.IPv4ProtocolNumber, 1); ok {
+		t.Error(`matchRevision("conntrack", IPv4, 1) found, want not found (registered revision is 2)`)
+	}
+	if rev, ok := matchRevision("state", header.IPv4ProtocolNumber, 0); !ok || rev != 0 {
+		t.Errorf(`matchRevision("state", IPv4, 0) = (%d, %v), want (0, true)`, rev, ok)
+	}
+}
+
+func TestGetRevisionSockOpt(t *testing.T) {
+	var rev linux.XTGetRevision
+	copy(rev.Name[:], "state")
+	rev.Revision = 0
+	buf := make([]byte, rev.SizeBytes())
+	rev.MarshalUnsafe(buf)
+
+	result, err, handled := GetRevisionSockOpt(linux.IPT_SO_GET_REVISION_MATCH, buf, header.IPv4ProtocolNumber)
+	if !handled {
+		t.Fatal("GetRevisionSockOpt(IPT_SO_GET_REVISION_MATCH) not handled, want handled")
+	}
+	if err != nil {
+		t.Fatalf("GetRevisionSockOpt(IPT_SO_GET_REVISION_MATCH) err = %v, want nil", err)
+	}
+	if result == nil {
+		t.Fatal("GetRevisionSockOpt(IPT_SO_GET_REVISION_MATCH) result = nil, want non-nil")
+	}
+
+	if _, _, handled := GetRevisionSockOpt(-1, buf, header.IPv4ProtocolNumber); handled {
+		t.Error("GetRevisionSockOpt(-1) handled = true, want false for an unrelated socket option")
+	}
+}
+
+func TestGetRevisionSockOptUnsupportedName(t *testing.T) {
+	var rev linux.XTGetRevision
+	copy(rev.Name[:], "no-such-matcher")
+	buf := make([]byte, rev.SizeBytes())
+	rev.MarshalUnsafe(buf)
+
+	_, err, handled := GetRevisionSockOpt(linux.IPT_SO_GET_REVISION_MATCH, buf, header.IPv4ProtocolNumber)
+	if !handled {
+		t.Fatal("GetRevisionSockOpt(IPT_SO_GET_REVISION_MATCH) not handled, want handled")
+	}
+	if err == nil {
+		t.Error("GetRevisionSockOpt for an unsupported matcher name succeeded, want an error")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// natNetProtos are the network protocols NAT targets are registered for;
+// like every other target in this package, each is keyed by the protocol of
+// the table it was installed into (see unmarshalTarget), not registered
+// protocol-agnostically.
+var natNetProtos = []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber}
+
+func init() {
+	for _, netProto := range natNetProtos {
+		registerTargetMaker(&natTargetMaker{targetName: stack.SNATTargetName, netProto: netProto})
+		registerTargetMaker(&natTargetMaker{targetName: stack.DNATTargetName, netProto: netProto})
+		registerTargetMaker(&masqueradeTargetMaker{netProto: netProto})
+		registerTargetMaker(&redirectTargetMaker{netProto: netProto})
+	}
+}
+
+// natRangeFromCompat converts the legacy nf_nat_ipv4_multi_range_compat
+// wire struct to a stack.NATRange.
+func natRangeFromCompat(rng linux.NFNATRange) stack.NATRange {
+	out := stack.NATRange{
+		MinPort: rng.MinProto,
+		MaxPort: rng.MaxProto,
+	}
+	if rng.Flags&linux.NFNATRangeMapIPs != 0 {
+		out.MinAddr = tcpip.Address(rng.MinAddr[:])
+		out.MaxAddr = tcpip.Address(rng.MaxAddr[:])
+	}
+	return out
+}
+
+// compatFromNATRange is the inverse of natRangeFromCompat, used when
+// marshalling a target back out to userspace (e.g. for LIST).
+func compatFromNATRange(rng stack.NATRange) linux.NFNATRange {
+	var out linux.NFNATRange
+	out.MinProto = rng.MinPort
+	out.MaxProto = rng.MaxPort
+	if len(rng.MinAddr) != 0 {
+		out.Flags |= linux.NFNATRangeMapIPs
+		copy(out.MinAddr[:], rng.MinAddr)
+		copy(out.MaxAddr[:], rng.MaxAddr)
+	}
+	return out
+}
+
+// natTargetMaker marshals and unmarshals the nf_nat_ipv4_multi_range_compat
+// struct shared by the SNAT and DNAT targets; they differ only in which
+// half of the connection tuple gets rewritten.
+type natTargetMaker struct {
+	targetName string
+	netProto   tcpip.NetworkProtocolNumber
+}
+
+// id implements targetMaker.id.
+func (tm *natTargetMaker) id() stack.TargetID {
+	return stack.TargetID{Name: tm.targetName, NetworkProtocol: tm.netProto}
+}
+
+// marshal implements targetMaker.marshal.
+func (tm *natTargetMaker) marshal(target stack.Target) []byte {
+	var rng stack.NATRange
+	switch t := target.(type) {
+	case *stack.SNATTarget:
+		rng = t.NATRange
+	case *stack.DNATTarget:
+		rng = t.NATRange
+	}
+	compat := linux.XTNATRangeCompat{RangeSize: 1, Range: compatFromNATRange(rng)}
+	buf := make([]byte, linux.SizeOfXTNATRangeCompat)
+	compat.MarshalUnsafe(buf)
+	return marshalEntryTarget(tm.targetName, buf)
+}
+
+// unmarshal implements targetMaker.unmarshal.
+func (tm *natTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Target, *syserr.Error) {
+	if len(buf) < linux.SizeOfXTNATRangeCompat {
+		nflog("natTargetMaker: buf has insufficient size for %s %d", tm.targetName, len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var compat linux.XTNATRangeCompat
+	compat.UnmarshalUnsafe(buf)
+	rng := natRangeFromCompat(compat.Range)
+
+	switch tm.targetName {
+	case stack.SNATTargetName:
+		return &stack.SNATTarget{NATRange: rng, NetworkProtocol: filter.NetworkProtocol()}, nil
+	case stack.DNATTargetName:
+		return &stack.DNATTarget{NATRange: rng, NetworkProtocol: filter.NetworkProtocol()}, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+// masqueradeTargetMaker marshals and unmarshals the MASQUERADE target,
+// which only carries an optional port range (the source address is always
+// the outgoing interface's address, chosen at rule-hit time).
+type masqueradeTargetMaker struct {
+	netProto tcpip.NetworkProtocolNumber
+}
+
+// id implements targetMaker.id.
+func (tm *masqueradeTargetMaker) id() stack.TargetID {
+	return stack.TargetID{Name: stack.MasqueradeTargetName, NetworkProtocol: tm.netProto}
+}
+
+// marshal implements targetMaker.marshal.
+func (*masqueradeTargetMaker) marshal(target stack.Target) []byte {
+	t := target.(*stack.MasqueradeTarget)
+	rng := linux.NFNATRange{MinProto: t.MinPort, MaxProto: t.MaxPort}
+	if t.MinPort != 0 || t.MaxPort != 0 {
+		rng.Flags |= linux.NFNATRangeProtoSpecified
+	}
+	compat := linux.XTNATRangeCompat{RangeSize: 1, Range: rng}
+	buf := make([]byte, linux.SizeOfXTNATRangeCompat)
+	compat.MarshalUnsafe(buf)
+	return marshalEntryTarget(stack.MasqueradeTargetName, buf)
+}
+
+// unmarshal implements targetMaker.unmarshal.
+func (*masqueradeTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Target, *syserr.Error) {
+	if len(buf) < linux.SizeOfXTNATRangeCompat {
+		nflog("masqueradeTargetMaker: buf has insufficient size %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var compat linux.XTNATRangeCompat
+	compat.UnmarshalUnsafe(buf)
+	return &stack.MasqueradeTarget{MinPort: compat.Range.MinProto, MaxPort: compat.Range.MaxProto, NetworkProtocol: filter.NetworkProtocol()}, nil
+}
+
+// redirectTargetMaker marshals and unmarshals the REDIRECT target, which
+// like MASQUERADE only carries an optional port range.
+type redirectTargetMaker struct {
+	netProto tcpip.NetworkProtocolNumber
+}
+
+// id implements targetMaker.id.
+func (tm *redirectTargetMaker) id() stack.TargetID {
+	return stack.TargetID{Name: stack.RedirectTargetName, NetworkProtocol: tm.netProto}
+}
+
+// marshal implements targetMaker.marshal.
+func (*redirectTargetMaker) marshal(target stack.Target) []byte {
+	t := target.(*stack.RedirectTarget)
+	rng := linux.NFNATRange{MinProto: t.MinPort, MaxProto: t.MaxPort}
+	if t.MinPort != 0 || t.MaxPort != 0 {
+		rng.Flags |= linux.NFNATRangeProtoSpecified
+	}
+	compat := linux.XTNATRangeCompat{RangeSize: 1, Range: rng}
+	buf := make([]byte, linux.SizeOfXTNATRangeCompat)
+	compat.MarshalUnsafe(buf)
+	return marshalEntryTarget(stack.RedirectTargetName, buf)
+}
+
+// unmarshal implements targetMaker.unmarshal.
+func (*redirectTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Target, *syserr.Error) {
+	if len(buf) < linux.SizeOfXTNATRangeCompat {
+		nflog("redirectTargetMaker: buf has insufficient size %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var compat linux.XTNATRangeCompat
+	compat.UnmarshalUnsafe(buf)
+	return &stack.RedirectTarget{MinPort: compat.Range.MinProto, MaxPort: compat.Range.MaxProto, NetworkProtocol: filter.NetworkProtocol()}, nil
+}
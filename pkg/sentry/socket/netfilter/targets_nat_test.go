@@ -0,0 +1,130 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestNATTargetMakersRegisteredPerNetProto(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		netProto tcpip.NetworkProtocolNumber
+	}{
+		{name: stack.SNATTargetName, netProto: header.IPv4ProtocolNumber},
+		{name: stack.SNATTargetName, netProto: header.IPv6ProtocolNumber},
+		{name: stack.DNATTargetName, netProto: header.IPv4ProtocolNumber},
+		{name: stack.MasqueradeTargetName, netProto: header.IPv4ProtocolNumber},
+		{name: stack.RedirectTargetName, netProto: header.IPv4ProtocolNumber},
+	} {
+		id := stack.TargetID{Name: test.name, NetworkProtocol: test.netProto}
+		if _, ok := targetMakers[id]; !ok {
+			t.Errorf("targetMakers[%+v] not registered", id)
+		}
+	}
+}
+
+func TestNATRangeFromCompatRoundTrip(t *testing.T) {
+	rng := stack.NATRange{
+		MinAddr: tcpip.Address("\x01\x02\x03\x04"),
+		MaxAddr: tcpip.Address("\x01\x02\x03\x05"),
+		MinPort: 1000,
+		MaxPort: 2000,
+	}
+	compat := compatFromNATRange(rng)
+	got := natRangeFromCompat(compat)
+	if got.MinAddr != rng.MinAddr || got.MaxAddr != rng.MaxAddr || got.MinPort != rng.MinPort || got.MaxPort != rng.MaxPort {
+		t.Errorf("natRangeFromCompat(compatFromNATRange(%+v)) = %+v, want the same range back", rng, got)
+	}
+}
+
+func TestNATRangeFromCompatNoAddr(t *testing.T) {
+	// Without NFNATRangeMapIPs set (e.g. a port-only rule), the address
+	// must come back unset so performNAT's zero-means-unchanged fallback
+	// kicks in.
+	rng := stack.NATRange{MinPort: 1000, MaxPort: 1000}
+	compat := compatFromNATRange(rng)
+	if compat.Flags&linux.NFNATRangeMapIPs != 0 {
+		t.Errorf("compatFromNATRange(%+v).Flags has NFNATRangeMapIPs set, want unset", rng)
+	}
+	got := natRangeFromCompat(compat)
+	if len(got.MinAddr) != 0 || len(got.MaxAddr) != 0 {
+		t.Errorf("natRangeFromCompat round trip = %+v, want zero-length addresses", got)
+	}
+}
+
+func TestNatTargetMakerUnmarshal(t *testing.T) {
+	tm := &natTargetMaker{targetName: stack.SNATTargetName, netProto: header.IPv4ProtocolNumber}
+
+	rng := linux.NFNATRange{MinProto: 1000, MaxProto: 1000, Flags: linux.NFNATRangeMapIPs}
+	copy(rng.MinAddr[:], []byte{1, 2, 3, 4})
+	copy(rng.MaxAddr[:], []byte{1, 2, 3, 4})
+	compat := linux.XTNATRangeCompat{RangeSize: 1, Range: rng}
+	buf := make([]byte, linux.SizeOfXTNATRangeCompat)
+	compat.MarshalUnsafe(buf)
+
+	filter := stack.IPHeaderFilter{}
+	target, err := tm.unmarshal(buf, filter)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	snat, ok := target.(*stack.SNATTarget)
+	if !ok {
+		t.Fatalf("unmarshal returned %T, want *stack.SNATTarget", target)
+	}
+	if snat.MinPort != 1000 || snat.MaxPort != 1000 {
+		t.Errorf("MinPort/MaxPort = %d/%d, want 1000/1000", snat.MinPort, snat.MaxPort)
+	}
+	if snat.NetworkProtocol != filter.NetworkProtocol() {
+		t.Errorf("NetworkProtocol = %v, want %v (filter.NetworkProtocol())", snat.NetworkProtocol, filter.NetworkProtocol())
+	}
+}
+
+func TestMasqueradeTargetMakerUnmarshal(t *testing.T) {
+	tm := &masqueradeTargetMaker{netProto: header.IPv4ProtocolNumber}
+
+	rng := linux.NFNATRange{MinProto: 500, MaxProto: 600, Flags: linux.NFNATRangeProtoSpecified}
+	compat := linux.XTNATRangeCompat{RangeSize: 1, Range: rng}
+	buf := make([]byte, linux.SizeOfXTNATRangeCompat)
+	compat.MarshalUnsafe(buf)
+
+	filter := stack.IPHeaderFilter{}
+	target, err := tm.unmarshal(buf, filter)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	masq, ok := target.(*stack.MasqueradeTarget)
+	if !ok {
+		t.Fatalf("unmarshal returned %T, want *stack.MasqueradeTarget", target)
+	}
+	if masq.MinPort != 500 || masq.MaxPort != 600 {
+		t.Errorf("MinPort/MaxPort = %d/%d, want 500/600", masq.MinPort, masq.MaxPort)
+	}
+	if masq.NetworkProtocol != filter.NetworkProtocol() {
+		t.Errorf("NetworkProtocol = %v, want %v (filter.NetworkProtocol())", masq.NetworkProtocol, filter.NetworkProtocol())
+	}
+}
+
+func TestNatTargetMakerUnmarshalBufTooSmall(t *testing.T) {
+	tm := &natTargetMaker{targetName: stack.SNATTargetName, netProto: header.IPv4ProtocolNumber}
+	if _, err := tm.unmarshal(make([]byte, linux.SizeOfXTNATRangeCompat-1), stack.IPHeaderFilter{}); err == nil {
+		t.Error("unmarshal with a too-small buffer succeeded, want an error")
+	}
+}
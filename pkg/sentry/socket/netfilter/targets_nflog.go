@@ -0,0 +1,87 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func init() {
+	for _, netProto := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+		registerTargetMaker(&nflogTargetMaker{nflogName: stack.NFLOGTargetName, netProto: netProto})
+		registerTargetMaker(&nflogTargetMaker{nflogName: stack.ULOGTargetName, netProto: netProto})
+	}
+}
+
+// nflogTargetMaker marshals and unmarshals the xt_nflog_info struct used by
+// both the NFLOG and legacy ULOG targets; ULOG packets are delivered through
+// the same nfnetlink_log backend as NFLOG (see stack.NFLOGTarget).
+type nflogTargetMaker struct {
+	// nflogName distinguishes NFLOG from ULOG target registrations; the
+	// wire format and runtime behavior are otherwise identical.
+	nflogName string
+
+	// netProto is the network protocol (IPv4 or IPv6) this registration
+	// was installed for; like every other targetMaker in this package, a
+	// target is registered once per protocol since unmarshalTarget keys
+	// its lookup on the table's actual protocol.
+	netProto tcpip.NetworkProtocolNumber
+}
+
+// id implements targetMaker.id.
+func (tm *nflogTargetMaker) id() stack.TargetID {
+	return stack.TargetID{Name: tm.nflogName, NetworkProtocol: tm.netProto}
+}
+
+// marshal implements targetMaker.marshal.
+func (tm *nflogTargetMaker) marshal(target stack.Target) []byte {
+	nflogt := target.(*stack.NFLOGTarget)
+	nfinfo := linux.XTNFLogInfo{
+		Len:   nflogt.Len,
+		Group: nflogt.Group,
+	}
+	copy(nfinfo.Prefix[:], nflogt.Prefix)
+	buf := make([]byte, linux.SizeOfXTNFLogInfo)
+	nfinfo.MarshalUnsafe(buf)
+	return marshalEntryTarget(tm.nflogName, buf)
+}
+
+// unmarshal implements targetMaker.unmarshal.
+func (tm *nflogTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Target, *syserr.Error) {
+	if len(buf) < linux.SizeOfXTNFLogInfo {
+		nflog("nflogTargetMaker: buf has insufficient size for nflog info %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	var nfinfo linux.XTNFLogInfo
+	nfinfo.UnmarshalUnsafe(buf)
+
+	prefixLen := 0
+	for prefixLen < len(nfinfo.Prefix) && nfinfo.Prefix[prefixLen] != 0 {
+		prefixLen++
+	}
+
+	return &stack.NFLOGTarget{
+		Name:            tm.nflogName,
+		NetworkProtocol: filter.NetworkProtocol(),
+		Len:             nfinfo.Len,
+		Group:           nfinfo.Group,
+		Prefix:          string(nfinfo.Prefix[:prefixLen]),
+	}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// TestNFLOGTargetMakerRegisteredPerNetProto is a regression test for the
+// target-ID bug that made every NFLOG/ULOG rule fail to unmarshal:
+// nflogTargetMaker used to register with NetworkProtocol 0, but
+// unmarshalTarget looks targets up by the table's real network protocol
+// (see extensions.go's unmarshalTarget), which is never 0.
+func TestNFLOGTargetMakerRegisteredPerNetProto(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		netProto tcpip.NetworkProtocolNumber
+	}{
+		{name: stack.NFLOGTargetName, netProto: header.IPv4ProtocolNumber},
+		{name: stack.NFLOGTargetName, netProto: header.IPv6ProtocolNumber},
+		{name: stack.ULOGTargetName, netProto: header.IPv4ProtocolNumber},
+		{name: stack.ULOGTargetName, netProto: header.IPv6ProtocolNumber},
+	} {
+		id := stack.TargetID{Name: test.name, NetworkProtocol: test.netProto}
+		if _, ok := targetMakers[id]; !ok {
+			t.Errorf("targetMakers[%+v] not registered", id)
+		}
+	}
+}
+
+func TestNflogTargetMakerUnmarshal(t *testing.T) {
+	tm := &nflogTargetMaker{nflogName: stack.NFLOGTargetName, netProto: header.IPv4ProtocolNumber}
+
+	nfinfo := linux.XTNFLogInfo{Len: 128, Group: 5}
+	copy(nfinfo.Prefix[:], "my-prefix")
+	buf := make([]byte, linux.SizeOfXTNFLogInfo)
+	nfinfo.MarshalUnsafe(buf)
+
+	filter := stack.IPHeaderFilter{}
+	target, err := tm.unmarshal(buf, filter)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	nflogTarget, ok := target.(*stack.NFLOGTarget)
+	if !ok {
+		t.Fatalf("unmarshal returned %T, want *stack.NFLOGTarget", target)
+	}
+	if nflogTarget.Name != stack.NFLOGTargetName {
+		t.Errorf("Name = %q, want %q", nflogTarget.Name, stack.NFLOGTargetName)
+	}
+	if nflogTarget.Len != 128 {
+		t.Errorf("Len = %d, want 128", nflogTarget.Len)
+	}
+	if nflogTarget.Group != 5 {
+		t.Errorf("Group = %d, want 5", nflogTarget.Group)
+	}
+	if nflogTarget.Prefix != "my-prefix" {
+		t.Errorf("Prefix = %q, want %q", nflogTarget.Prefix, "my-prefix")
+	}
+	// The whole point of this round trip: the unmarshalled target's ID
+	// must match what unmarshalTarget actually looks targets up by,
+	// filter.NetworkProtocol(), not a hardcoded 0.
+	if nflogTarget.NetworkProtocol != filter.NetworkProtocol() {
+		t.Errorf("NetworkProtocol = %v, want %v (filter.NetworkProtocol())", nflogTarget.NetworkProtocol, filter.NetworkProtocol())
+	}
+}
+
+func TestNflogTargetMakerUnmarshalBufTooSmall(t *testing.T) {
+	tm := &nflogTargetMaker{nflogName: stack.NFLOGTargetName, netProto: header.IPv4ProtocolNumber}
+	if _, err := tm.unmarshal(make([]byte, linux.SizeOfXTNFLogInfo-1), stack.IPHeaderFilter{}); err == nil {
+		t.Error("unmarshal with a too-small buffer succeeded, want an error")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+// ConnTrackStateMatcher implements Matcher for the "state" extension,
+// matching a packet's NEW/ESTABLISHED/RELATED/INVALID classification as
+// reported by ConnTrack.Status.
+type ConnTrackStateMatcher struct {
+	// StateMask is a bitmask of linux.XTStateBit* bits; the matcher
+	// succeeds if the packet's status bit is set in StateMask.
+	StateMask uint32
+}
+
+// Name implements Matcher.Name.
+func (*ConnTrackStateMatcher) Name() string {
+	return "state"
+}
+
+// Match implements Matcher.Match.
+func (m *ConnTrackStateMatcher) Match(hook Hook, pkt *PacketBuffer, inNICName, outNICName string) (matches bool, hotdrop bool) {
+	status, _, _, _, _, _, ok := statusFor(pkt, hook)
+	if !ok {
+		status = ConnStatusInvalid
+	}
+	return m.StateMask&stateBitFor(status) != 0, false
+}
+
+// ConnTrackTupleMatcher implements Matcher for the "conntrack" extension, a
+// superset of "state" that can additionally match on the connection's
+// original-direction protocol and ports.
+type ConnTrackTupleMatcher struct {
+	// StateMask is as ConnTrackStateMatcher.StateMask, except that zero
+	// places no constraint on the state of a tracked packet. An untracked
+	// packet matches only if StateMask includes the INVALID bit.
+	StateMask uint32
+
+	// OrigProto, if non-zero, must equal the connection's original
+	// transport protocol for the matcher to succeed.
+	OrigProto uint8
+
+	// OrigSrcPort and OrigDstPort, if non-zero, must equal the
+	// connection's original-direction ports for the matcher to succeed.
+	OrigSrcPort, OrigDstPort uint16
+}
+
+// Name implements Matcher.Name.
+func (*ConnTrackTupleMatcher) Name() string {
+	return "conntrack"
+}
+
+// Match implements Matcher.Match.
+func (m *ConnTrackTupleMatcher) Match(hook Hook, pkt *PacketBuffer, inNICName, outNICName string) (matches bool, hotdrop bool) {
+	status, _, _, origSrcPort, origDstPort, transProto, ok := statusFor(pkt, hook)
+	if !ok {
+		return m.StateMask&stateBitFor(ConnStatusInvalid) != 0, false
+	}
+	if m.StateMask != 0 && m.StateMask&stateBitFor(status) == 0 {
+		return false, false
+	}
+	if m.OrigProto != 0 && uint8(transProto) != m.OrigProto {
+		return false, false
+	}
+	if m.OrigSrcPort != 0 && origSrcPort != m.OrigSrcPort {
+		return false, false
+	}
+	if m.OrigDstPort != 0 && origDstPort != m.OrigDstPort {
+		return false, false
+	}
+	return true, false
+}
+
+// stateBitFor maps a ConnStatus to the linux.XTStateBit* mask bit userspace
+// uses to query it.
+func stateBitFor(status ConnStatus) uint32 {
+	switch status {
+	case ConnStatusNew:
+		return 0x08
+	case ConnStatusEstablished:
+		return 0x02
+	case ConnStatusRelated:
+		return 0x04
+	default:
+		return 0x01
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestConnTrackStateMatcherUntrackedPacket is a regression test for the
+// nil-safe conntrack lookup fix: a packet with no tracked tuple (e.g. no
+// NAT-table rule has run for it yet) must be classified INVALID rather than
+// panicking inside statusFor.
+func TestConnTrackStateMatcherUntrackedPacket(t *testing.T) {
+	m := &ConnTrackStateMatcher{StateMask: stateBitFor(ConnStatusInvalid)}
+	var hook Hook
+	pkt := &PacketBuffer{}
+
+	matches, hotdrop := m.Match(hook, pkt, "", "")
+	if hotdrop {
+		t.Error("Match hotdrop = true, want false")
+	}
+	if !matches {
+		t.Error("Match on an untracked packet with StateMask including INVALID = false, want true")
+	}
+}
+
+func TestConnTrackStateMatcherUntrackedPacketNoInvalidBit(t *testing.T) {
+	m := &ConnTrackStateMatcher{StateMask: stateBitFor(ConnStatusEstablished)}
+	var hook Hook
+	pkt := &PacketBuffer{}
+
+	matches, hotdrop := m.Match(hook, pkt, "", "")
+	if hotdrop {
+		t.Error("Match hotdrop = true, want false")
+	}
+	if matches {
+		t.Error("Match on an untracked packet with StateMask excluding INVALID = true, want false")
+	}
+}
+
+func TestConnTrackTupleMatcherUntrackedPacket(t *testing.T) {
+	m := &ConnTrackTupleMatcher{StateMask: stateBitFor(ConnStatusInvalid)}
+	var hook Hook
+	pkt := &PacketBuffer{}
+
+	matches, hotdrop := m.Match(hook, pkt, "", "")
+	if hotdrop {
+		t.Error("Match hotdrop = true, want false")
+	}
+	if !matches {
+		t.Error("Match on an untracked packet with StateMask including INVALID = false, want true")
+	}
+}
+
+func TestStateBitFor(t *testing.T) {
+	for _, test := range []struct {
+		status ConnStatus
+		want   uint32
+	}{
+		{status: ConnStatusNew, want: 0x08},
+		{status: ConnStatusEstablished, want: 0x02},
+		{status: ConnStatusRelated, want: 0x04},
+		{status: ConnStatusInvalid, want: 0x01},
+	} {
+		if got := stateBitFor(test.status); got != test.want {
+			t.Errorf("stateBitFor(%v) = %#x, want %#x", test.status, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "gvisor.dev/gvisor/pkg/tcpip"
+
+// ConnStatus categorizes a tracked connection the way Linux's conntrack
+// reports it to the "state"/"conntrack" matchers (NEW/ESTABLISHED/RELATED/
+// INVALID), as distinct from the internal tcpConnTrackState used to expire
+// TCP connections.
+type ConnStatus int
+
+// Connection statuses, exposed to the xt_state and xt_conntrack matchers.
+const (
+	// ConnStatusInvalid is returned for packets conntrack could not
+	// associate with a tracked connection (e.g. malformed tuples).
+	ConnStatusInvalid ConnStatus = iota
+
+	// ConnStatusNew is returned for the first packet of a connection, before
+	// a reply in the other direction has been seen.
+	ConnStatusNew
+
+	// ConnStatusEstablished is returned once both directions of a
+	// connection have been observed.
+	ConnStatusEstablished
+
+	// ConnStatusRelated is returned for packets belonging to a helper
+	// connection related to an existing tracked connection (e.g. an FTP
+	// data channel), which gVisor does not currently track separately and
+	// so never returns from Status.
+	ConnStatusRelated
+)
+
+// Status is how the state/conntrack matchers query conntrack state.
+//
+// Status returns the tracked status of pkt relative to hook, and the
+// original-direction tuple of its connection, if one is tracked. ok is false
+// if no connection is being tracked for pkt.
+func (ct *ConnTrack) Status(pkt *PacketBuffer, hook Hook) (status ConnStatus, origSrcAddr, origDstAddr tcpip.Address, origSrcPort, origDstPort uint16, transProto tcpip.TransportProtocolNumber, ok bool) {
+	conn, dir := ct.connFor(pkt)
+	if conn == nil {
+		return ConnStatusInvalid, "", "", 0, 0, 0, false
+	}
+
+	orig := conn.originalTupleID()
+	status = ConnStatusNew
+	if conn.seenReply() {
+		status = ConnStatusEstablished
+	}
+	if dir == dirReply {
+		status = ConnStatusEstablished
+	}
+
+	return status, orig.srcAddr, orig.dstAddr, orig.srcPort, orig.dstPort, orig.transProto, true
+}
+
+// statusFor is a nil-safe wrapper around ConnTrack.Status for callers, like
+// the state/conntrack matchers, that only have a *PacketBuffer and not a
+// direct handle on the ConnTrack instance that may (or may not) have already
+// tracked it. It reports ConnStatusInvalid/ok=false instead of panicking
+// when pkt has no tracked tuple, e.g. because no NAT-table rule has run yet.
+func statusFor(pkt *PacketBuffer, hook Hook) (status ConnStatus, origSrcAddr, origDstAddr tcpip.Address, origSrcPort, origDstPort uint16, transProto tcpip.TransportProtocolNumber, ok bool) {
+	if pkt.tuple == nil || pkt.tuple.conn == nil || pkt.tuple.conn.ct == nil {
+		return ConnStatusInvalid, "", "", 0, 0, 0, false
+	}
+	return pkt.tuple.conn.ct.Status(pkt, hook)
+}
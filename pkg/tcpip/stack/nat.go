@@ -0,0 +1,66 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+// performNAT rewrites pkt's source or destination address/port according to
+// rng, and records the rewrite on pkt's tracked connection so that the reply
+// direction of the flow is translated back automatically.
+//
+// performNAT picks the first address/port in rng deterministically (gVisor
+// does not implement the load-balancing hash Linux uses across multiple
+// candidate addresses/ports, since rules rewriting to a range wider than a
+// single address are vanishingly rare in practice).
+func (ct *ConnTrack) performNAT(pkt *PacketBuffer, hook Hook, r *Route, rng NATRange, typ natType) {
+	conn, _ := ct.connFor(pkt)
+	if conn == nil {
+		// No tracked connection (e.g. NAT applied before the conntrack
+		// hook ran); nothing to rewrite or remember.
+		return
+	}
+
+	// NATRange leaves the address and/or port unchanged when the
+	// corresponding fields are zero (see NATRange's doc); fall back to the
+	// connection's original-direction address/port so a rule that only
+	// specifies one of the two (e.g. "-j SNAT --to-source 1.2.3.4" with no
+	// port range) doesn't zero out the other.
+	orig := conn.originalTupleID()
+
+	newAddr := rng.MinAddr
+	if len(newAddr) == 0 {
+		switch typ {
+		case natTypeSource:
+			newAddr = orig.srcAddr
+		case natTypeDestination:
+			newAddr = orig.dstAddr
+		}
+	}
+
+	newPort := rng.MinPort
+	if rng.MinPort == 0 && rng.MaxPort == 0 {
+		switch typ {
+		case natTypeSource:
+			newPort = orig.srcPort
+		case natTypeDestination:
+			newPort = orig.dstPort
+		}
+	}
+
+	switch typ {
+	case natTypeSource:
+		conn.performSourceRewrite(pkt, newAddr, newPort)
+	case natTypeDestination:
+		conn.performDestinationRewrite(pkt, newAddr, newPort)
+	}
+}
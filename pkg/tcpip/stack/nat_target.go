@@ -0,0 +1,148 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "gvisor.dev/gvisor/pkg/tcpip"
+
+// NATRange is the range of addresses and ports a NAT target may rewrite a
+// connection's source or destination to, mirroring Linux's nf_nat_range.
+type NATRange struct {
+	// MinAddr and MaxAddr bound the address a packet may be rewritten to.
+	// If MinAddr is the zero tcpip.Address, the address is left unchanged
+	// (e.g. a SNAT/DNAT rule that only specifies a port range).
+	MinAddr, MaxAddr tcpip.Address
+
+	// MinPort and MaxPort bound the port a packet may be rewritten to. If
+	// both are zero, the port is left unchanged.
+	MinPort, MaxPort uint16
+}
+
+// SNATTarget rewrites a packet's source address/port, translating the reply
+// direction of the connection symmetrically. It implements Target.
+type SNATTarget struct {
+	NATRange
+
+	// NetworkProtocol is the network protocol of the table this target was
+	// installed into; it identifies which natTargetMaker registration can
+	// marshal this target back out to userspace.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// ID implements Target.ID.
+func (t *SNATTarget) ID() TargetID {
+	return TargetID{Name: SNATTargetName, NetworkProtocol: t.NetworkProtocol}
+}
+
+// Action implements Target.Action.
+func (t *SNATTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, r *Route, address tcpip.Address) (RuleVerdict, int) {
+	if ct == nil {
+		return RuleDrop, 0
+	}
+	ct.performNAT(pkt, hook, r, t.NATRange, natTypeSource)
+	return RuleAccept, 0
+}
+
+// DNATTarget rewrites a packet's destination address/port. It implements
+// Target.
+type DNATTarget struct {
+	NATRange
+
+	// NetworkProtocol is as SNATTarget.NetworkProtocol.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// ID implements Target.ID.
+func (t *DNATTarget) ID() TargetID {
+	return TargetID{Name: DNATTargetName, NetworkProtocol: t.NetworkProtocol}
+}
+
+// Action implements Target.Action.
+func (t *DNATTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, r *Route, address tcpip.Address) (RuleVerdict, int) {
+	if ct == nil {
+		return RuleDrop, 0
+	}
+	ct.performNAT(pkt, hook, r, t.NATRange, natTypeDestination)
+	return RuleAccept, 0
+}
+
+// MasqueradeTarget is a SNATTarget whose source address is always the
+// outgoing interface's primary address, discovered per-packet from the
+// route rather than a fixed NATRange. It implements Target.
+type MasqueradeTarget struct {
+	// MinPort and MaxPort, if non-zero, bound the rewritten port like
+	// NATRange.
+	MinPort, MaxPort uint16
+
+	// NetworkProtocol is as SNATTarget.NetworkProtocol.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// ID implements Target.ID.
+func (t *MasqueradeTarget) ID() TargetID {
+	return TargetID{Name: MasqueradeTargetName, NetworkProtocol: t.NetworkProtocol}
+}
+
+// Action implements Target.Action.
+func (t *MasqueradeTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, r *Route, address tcpip.Address) (RuleVerdict, int) {
+	if ct == nil || hook != Postrouting {
+		return RuleDrop, 0
+	}
+	rng := NATRange{MinAddr: r.LocalAddress(), MaxAddr: r.LocalAddress(), MinPort: t.MinPort, MaxPort: t.MaxPort}
+	ct.performNAT(pkt, hook, r, rng, natTypeSource)
+	return RuleAccept, 0
+}
+
+// RedirectTarget redirects a packet to the local host, rewriting its
+// destination to one of the host's own addresses. It implements Target.
+type RedirectTarget struct {
+	// MinPort and MaxPort bound the local port the packet is redirected to.
+	// If both are zero, the packet's destination port is left unchanged.
+	MinPort, MaxPort uint16
+
+	// NetworkProtocol is as SNATTarget.NetworkProtocol.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// ID implements Target.ID.
+func (t *RedirectTarget) ID() TargetID {
+	return TargetID{Name: RedirectTargetName, NetworkProtocol: t.NetworkProtocol}
+}
+
+// Action implements Target.Action.
+func (t *RedirectTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, r *Route, address tcpip.Address) (RuleVerdict, int) {
+	if ct == nil {
+		return RuleDrop, 0
+	}
+	rng := NATRange{MinAddr: address, MaxAddr: address, MinPort: t.MinPort, MaxPort: t.MaxPort}
+	ct.performNAT(pkt, hook, r, rng, natTypeDestination)
+	return RuleAccept, 0
+}
+
+// Target names for the NAT family, used as TargetID.Name.
+const (
+	SNATTargetName       = "SNAT"
+	DNATTargetName       = "DNAT"
+	MasqueradeTargetName = "MASQUERADE"
+	RedirectTargetName   = "REDIRECT"
+)
+
+// natType distinguishes which half of a connection's tuple performNAT
+// rewrites.
+type natType int
+
+const (
+	natTypeSource natType = iota
+	natTypeDestination
+)
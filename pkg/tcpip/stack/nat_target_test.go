@@ -0,0 +1,61 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+func TestNATTargetID(t *testing.T) {
+	const netProto = tcpip.NetworkProtocolNumber(0x0800)
+
+	for _, test := range []struct {
+		name string
+		id   func() TargetID
+	}{
+		{name: SNATTargetName, id: (&SNATTarget{NetworkProtocol: netProto}).ID},
+		{name: DNATTargetName, id: (&DNATTarget{NetworkProtocol: netProto}).ID},
+		{name: MasqueradeTargetName, id: (&MasqueradeTarget{NetworkProtocol: netProto}).ID},
+		{name: RedirectTargetName, id: (&RedirectTarget{NetworkProtocol: netProto}).ID},
+	} {
+		id := test.id()
+		if id.Name != test.name || id.NetworkProtocol != netProto {
+			t.Errorf("%s.ID() = %+v, want Name=%q NetworkProtocol=%#x", test.name, id, test.name, netProto)
+		}
+	}
+}
+
+// TestNATTargetActionDropsWithoutConnTrack checks that every NAT target
+// (SNAT, DNAT, REDIRECT and MASQUERADE) refuses to run without a ConnTrack to
+// rewrite and remember the connection through.
+func TestNATTargetActionDropsWithoutConnTrack(t *testing.T) {
+	var hook Hook
+	pkt := &PacketBuffer{}
+
+	if verdict, _ := (&SNATTarget{}).Action(pkt, nil, hook, nil, ""); verdict != RuleDrop {
+		t.Errorf("SNATTarget.Action with ct=nil verdict = %v, want RuleDrop", verdict)
+	}
+	if verdict, _ := (&DNATTarget{}).Action(pkt, nil, hook, nil, ""); verdict != RuleDrop {
+		t.Errorf("DNATTarget.Action with ct=nil verdict = %v, want RuleDrop", verdict)
+	}
+	if verdict, _ := (&RedirectTarget{}).Action(pkt, nil, hook, nil, ""); verdict != RuleDrop {
+		t.Errorf("RedirectTarget.Action with ct=nil verdict = %v, want RuleDrop", verdict)
+	}
+	if verdict, _ := (&MasqueradeTarget{}).Action(pkt, nil, hook, nil, ""); verdict != RuleDrop {
+		t.Errorf("MasqueradeTarget.Action with ct=nil verdict = %v, want RuleDrop", verdict)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "gvisor.dev/gvisor/pkg/tcpip"
+
+// NFLogMetadata carries the packet metadata that nfnetlink_log attaches
+// alongside the packet payload, mirroring what Linux's nfnetlink_log module
+// reports to userspace.
+type NFLogMetadata struct {
+	// Prefix is the user-configured string identifying the logging rule.
+	Prefix string
+
+	// Group is the nfnetlink_log multicast group the packet is published to.
+	Group uint16
+
+	// Hook is the netfilter hook the packet was logged from.
+	Hook Hook
+
+	// InNICName and OutNICName are the names of the incoming and outgoing
+	// interfaces, if known.
+	InNICName  string
+	OutNICName string
+
+	// Mark is the packet's firewall mark.
+	Mark uint32
+}
+
+// NFLogBackend receives packets logged by the NFLOG target. The sentry's
+// nfnetlink_log subsystem installs itself as the backend at init time via
+// SetNFLogBackend; until then, NFLOG targets are a silent no-op so that the
+// netstack package does not need to depend on the netlink socket layer.
+type NFLogBackend interface {
+	// Log delivers pkt and its metadata to subscribers of metadata.Group.
+	Log(pkt *PacketBuffer, metadata NFLogMetadata)
+}
+
+// nflogBackend is the currently installed NFLogBackend, or nil if none has
+// been installed.
+var nflogBackend NFLogBackend
+
+// SetNFLogBackend installs the backend used by NFLOGTarget to deliver logged
+// packets to nfnetlink_log subscribers. It is called once during sentry
+// initialization by the netlink nfnetlink_log provider.
+func SetNFLogBackend(b NFLogBackend) {
+	nflogBackend = b
+}
+
+// NFLOGTarget logs packets that reach it to the nfnetlink_log subsystem and
+// always continues rule traversal, mirroring Linux's NFLOG/ULOG targets.
+type NFLOGTarget struct {
+	// Name is either NFLOGTargetName or ULOGTargetName, depending on which
+	// target this was constructed as; both share this implementation since
+	// their wire format and runtime behavior are otherwise identical.
+	Name string
+
+	// NetworkProtocol is the network protocol of the table this target was
+	// installed into; it identifies which nflogTargetMaker registration can
+	// marshal this target back out to userspace.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+
+	// Prefix is copied into NFLogMetadata.Prefix for every logged packet.
+	Prefix string
+
+	// Group is the nfnetlink_log multicast group to publish to.
+	Group uint16
+
+	// Len is the xt_nflog_info copy length, kept so the target marshals
+	// back out unchanged. Action does not truncate to it: the whole packet
+	// is passed to the backend whatever its value.
+	Len uint32
+}
+
+// ID implements Target.ID.
+func (t *NFLOGTarget) ID() TargetID {
+	return TargetID{
+		Name:            t.Name,
+		NetworkProtocol: t.NetworkProtocol,
+	}
+}
+
+// Action implements Target.Action.
+func (t *NFLOGTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, r *Route, address tcpip.Address) (RuleVerdict, int) {
+	if nflogBackend != nil {
+		logPkt := pkt
+		if t.Len != 0 {
+			logPkt = pkt.Clone()
+		}
+		nflogBackend.Log(logPkt, NFLogMetadata{
+			Prefix: t.Prefix,
+			Group:  t.Group,
+			Hook:   hook,
+		})
+	}
+	return RuleContinue, 0
+}
+
+// NFLOGTargetName is used to mark targets as NFLOG targets.
+const NFLOGTargetName = "NFLOG"
+
+// ULOGTargetName is used to mark targets as the legacy ULOG targets. ULOG
+// packets are delivered through the same nfnetlink_log backend as NFLOG; the
+// original ULOG netlink multicast family is not implemented.
+const ULOGTargetName = "ULOG"
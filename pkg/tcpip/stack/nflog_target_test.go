@@ -0,0 +1,89 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// fakeNFLogBackend records the most recent Log call so tests can assert on
+// what NFLOGTarget.Action publishes.
+type fakeNFLogBackend struct {
+	calls    int
+	pkt      *PacketBuffer
+	metadata NFLogMetadata
+}
+
+func (b *fakeNFLogBackend) Log(pkt *PacketBuffer, metadata NFLogMetadata) {
+	b.calls++
+	b.pkt = pkt
+	b.metadata = metadata
+}
+
+func TestNFLOGTargetID(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		netProto tcpip.NetworkProtocolNumber
+	}{
+		{name: NFLOGTargetName, netProto: 0x0800},
+		{name: ULOGTargetName, netProto: 0x86DD},
+	} {
+		tgt := &NFLOGTarget{Name: test.name, NetworkProtocol: test.netProto}
+		id := tgt.ID()
+		if id.Name != test.name || id.NetworkProtocol != test.netProto {
+			t.Errorf("ID() = %+v, want Name=%q NetworkProtocol=%#x", id, test.name, test.netProto)
+		}
+	}
+}
+
+func TestNFLOGTargetActionPublishesToBackend(t *testing.T) {
+	backend := &fakeNFLogBackend{}
+	old := nflogBackend
+	SetNFLogBackend(backend)
+	defer SetNFLogBackend(old)
+
+	tgt := &NFLOGTarget{Name: ULOGTargetName, NetworkProtocol: 0x86DD, Prefix: "test-rule", Group: 7}
+	pkt := &PacketBuffer{}
+	var hook Hook
+	verdict, errNum := tgt.Action(pkt, nil, hook, nil, "")
+	if verdict != RuleContinue {
+		t.Errorf("Action verdict = %v, want RuleContinue", verdict)
+	}
+	if errNum != 0 {
+		t.Errorf("Action errNum = %d, want 0", errNum)
+	}
+	if backend.calls != 1 {
+		t.Fatalf("backend.calls = %d, want 1", backend.calls)
+	}
+	if backend.metadata.Prefix != "test-rule" || backend.metadata.Group != 7 {
+		t.Errorf("metadata = %+v, want Prefix=test-rule Group=7", backend.metadata)
+	}
+}
+
+func TestNFLOGTargetActionNoBackendIsNoop(t *testing.T) {
+	old := nflogBackend
+	SetNFLogBackend(nil)
+	defer SetNFLogBackend(old)
+
+	tgt := &NFLOGTarget{Name: NFLOGTargetName}
+	pkt := &PacketBuffer{}
+	var hook Hook
+	verdict, _ := tgt.Action(pkt, nil, hook, nil, "")
+	if verdict != RuleContinue {
+		t.Errorf("Action verdict = %v, want RuleContinue", verdict)
+	}
+}